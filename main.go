@@ -4,12 +4,21 @@ import (
 	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"math/rand"
+	"net"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"decred.org/dcrwallet/v2/rpc/client/dcrd"
 	"github.com/decred/dcrd/chaincfg/v3"
@@ -33,6 +42,8 @@ var (
 	xpubFlag = flag.String("xpub", "", "account xpub")
 	caCert   = flag.String("cacert", defaultCA(),
 		"dcrd RPC certificate")
+	caCertReload = flag.Duration("cacertreload", time.Minute,
+		"interval to re-read -cacert from disk (0 disables reloading)")
 	testnetFlag = flag.Bool("testnet", false,
 		"use testnet parameters")
 	externalFlag      = flag.Uint("external", 0, "external key count")
@@ -41,6 +52,32 @@ var (
 		"external starting index")
 	internalStartFlag = flag.Uint("internalstart", 0,
 		"internal starting index")
+	maxRetriesFlag = flag.Uint("maxretries", 5,
+		"maximum retries for a failed RPC call before giving up")
+	retryBaseFlag = flag.Duration("retrybase", 500*time.Millisecond,
+		"base delay for truncated exponential retry backoff")
+	retryCeilingFlag = flag.Duration("retryceiling", 30*time.Second,
+		"maximum delay between retries")
+	clientCertFlag = flag.String("clientcert", "",
+		"client certificate file for mutual TLS auth")
+	clientKeyFlag = flag.String("clientkey", "",
+		"client key file for mutual TLS auth")
+	insecureSkipVerifyFlag = flag.Bool("insecureskipverify", false,
+		"disable verification of the dcrd RPC server certificate")
+	serverNameFlag = flag.String("servername", "",
+		"expected server name in the dcrd RPC certificate")
+	workersFlag = flag.Uint("workers", 1,
+		"number of concurrent bucket-scanning workers")
+	formatFlag = flag.String("format", "text",
+		"output format: text, json, ndjson, or csv")
+	emitAddressesFlag = flag.Bool("emit-addresses", false,
+		"include each derived address and its used bit in the report")
+	algoFlag = flag.String("algo", "ecdsa-secp256k1",
+		"child pubkey hash address algorithm: ecdsa-secp256k1, ed25519, or schnorr-secp256k1")
+	p2shRedeemFlag = flag.String("p2sh-redeem", "",
+		"redeem script template with {pubkey} placeholders; "+
+			"when set, the P2SH address of the substituted script is "+
+			"queried in addition to the -algo address")
 )
 
 func main() {
@@ -66,52 +103,539 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
-	tc, err := tlsConfig("")
+	ctx := context.Background()
+	caPool, err := newCAPool(*caCert)
 	if err != nil {
 		log.Fatal(err)
 	}
-	ctx := context.Background()
-	c, err := wsrpc.Dial(ctx, *connectFlag, wsrpc.WithTLSConfig(tc),
-		wsrpc.WithBasicAuth(*userFlag, *passFlag))
+	go caPool.run(ctx, *caCertReload)
+	tc, err := tlsConfig(caPool, *serverNameFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	c, err := dialWithRetry(ctx, tc)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	rep, err := newReporter(*formatFlag)
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	u := &usage{
 		rpc:    dcrd.New(c),
+		c:      c,
+		caPool: caPool,
 		xpub:   xpub,
 		params: params,
+		rep:    rep,
+	}
+	if err := u.stats(ctx); err != nil {
+		log.Fatal(err)
 	}
-	u.stats(ctx)
 
-	c.Close()
+	u.c.Close()
 }
 
-func tlsConfig(serverName string) (*tls.Config, error) {
-	tc := &tls.Config{
-		ServerName: serverName,
-		RootCAs:    x509.NewCertPool(),
-	}
-	b, err := os.ReadFile(*caCert)
+// caPool holds a *x509.CertPool loaded from the -cacert file and allows it
+// to be swapped out at runtime by a background reloader, so that a cert
+// rotated on disk partway through a long scan does not require a restart.
+type caPool struct {
+	mu   sync.RWMutex
+	pool *x509.CertPool
+	path string
+}
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	b, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
-	if !tc.RootCAs.AppendCertsFromPEM(b) {
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
 		return nil, fmt.Errorf("failed to append certificates")
 	}
-	//kp, err := tls.LoadX509KeyPair(*clientCert, *clientKey)
-	//if err != nil {
-	//	return nil, fmt.Errorf("failed to read client keypair: %w",
-	//		err)
-	//}
-	//tc.Certificates = append(tc.Certificates, kp)
+	return pool, nil
+}
+
+func newCAPool(path string) (*caPool, error) {
+	pool, err := loadCertPool(path)
+	if err != nil {
+		return nil, err
+	}
+	return &caPool{pool: pool, path: path}, nil
+}
+
+func (c *caPool) current() *x509.CertPool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.pool
+}
+
+// reload re-reads the pool's certificate file from disk, swapping in the
+// new pool only if it parses cleanly.  A bad write to the cert file (e.g.
+// a partially-written rotation) leaves the previous pool installed rather
+// than failing an in-flight scan.
+func (c *caPool) reload() {
+	pool, err := loadCertPool(c.path)
+	if err != nil {
+		log.Printf("cacert: failed to reload %s, keeping previous pool: %v",
+			c.path, err)
+		return
+	}
+	c.mu.Lock()
+	c.pool = pool
+	c.mu.Unlock()
+	log.Printf("cacert: reloaded root CA pool from %s", c.path)
+}
+
+// run periodically reloads the pool from disk until ctx is done.  An
+// interval of 0 disables reloading.
+func (c *caPool) run(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			c.reload()
+		}
+	}
+}
+
+// tlsConfig builds a *tls.Config for dialing dcrd from pool's current CA
+// pool.  pool is long-lived (created once in main and reused across
+// redials) so its background reloader goroutine is started exactly once
+// per process rather than once per dial.
+//
+// GetConfigForClient is a server-side hook and is never invoked for an
+// outbound wsrpc.Dial, so the pool must be read into RootCAs directly;
+// it reflects whatever the pool has loaded as of this call, with later
+// reloads picked up by the next dial or redial.
+func tlsConfig(pool *caPool, serverName string) (*tls.Config, error) {
+	tc := &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: *insecureSkipVerifyFlag,
+		RootCAs:            pool.current(),
+	}
+
+	if *clientCertFlag != "" || *clientKeyFlag != "" {
+		if *clientCertFlag == "" || *clientKeyFlag == "" {
+			return nil, fmt.Errorf("-clientcert and -clientkey must both be set")
+		}
+		kp, err := newClientKeyPair(*clientCertFlag, *clientKeyFlag)
+		if err != nil {
+			return nil, err
+		}
+		tc.GetClientCertificate = kp.getClientCertificate
+	}
+
 	return tc, nil
 }
 
+// clientKeyPair holds a tls.Certificate loaded from the -clientcert and
+// -clientkey files and lazily re-reads them when the most recent mtime of
+// the two files changes, so a renewed keypair can be picked up on the next handshake
+// without restarting a long-running scan.
+type clientKeyPair struct {
+	mu       sync.RWMutex
+	cert     tls.Certificate
+	certPath string
+	keyPath  string
+	modTime  time.Time
+}
+
+// latestModTime stats each path and returns the most recent mtime among
+// them, so a rotation that only touches one of a pair of files is still
+// detected.
+func latestModTime(paths ...string) (time.Time, error) {
+	var latest time.Time
+	for _, p := range paths {
+		fi, err := os.Stat(p)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+func loadClientKeyPair(certPath, keyPath string) (tls.Certificate, time.Time, error) {
+	modTime, err := latestModTime(certPath, keyPath)
+	if err != nil {
+		return tls.Certificate{}, time.Time{}, err
+	}
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return tls.Certificate{}, time.Time{}, fmt.Errorf(
+			"failed to read client keypair: %w", err)
+	}
+	return cert, modTime, nil
+}
+
+func newClientKeyPair(certPath, keyPath string) (*clientKeyPair, error) {
+	cert, modTime, err := loadClientKeyPair(certPath, keyPath)
+	if err != nil {
+		return nil, err
+	}
+	return &clientKeyPair{
+		cert:     cert,
+		certPath: certPath,
+		keyPath:  keyPath,
+		modTime:  modTime,
+	}, nil
+}
+
+// getClientCertificate is installed as tls.Config.GetClientCertificate.
+// It re-reads the keypair from disk only when the most recent mtime of the
+// certificate and key files differs from the one recorded at the last
+// load, keeping renewal cheap.
+func (k *clientKeyPair) getClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	k.mu.RLock()
+	cert, certPath, keyPath, modTime := k.cert, k.certPath, k.keyPath, k.modTime
+	k.mu.RUnlock()
+
+	latest, err := latestModTime(certPath, keyPath)
+	if err != nil || latest.Equal(modTime) {
+		return &cert, nil
+	}
+	newCert, newModTime, err := loadClientKeyPair(certPath, keyPath)
+	if err != nil {
+		log.Printf("clientcert: failed to reload %s, keeping previous keypair: %v",
+			certPath, err)
+		return &cert, nil
+	}
+	k.mu.Lock()
+	k.cert = newCert
+	k.modTime = newModTime
+	k.mu.Unlock()
+	log.Printf("clientcert: reloaded client keypair from %s", certPath)
+	return &newCert, nil
+}
+
+// isRetryableError reports whether err is worth retrying.  RPC-level
+// errors such as a malformed request or a failed auth handshake indicate
+// a problem that a retry cannot fix, so only transport-level failures are
+// considered retryable.
+func isRetryableError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var rpcErr *wsrpc.Error
+	if errors.As(err, &rpcErr) {
+		return false
+	}
+	return true
+}
+
+// isClosedConnError reports whether err indicates the underlying
+// websocket transport is no longer usable and a redial is required.
+func isClosedConnError(err error) bool {
+	if errors.Is(err, net.ErrClosed) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// retryDelay computes the delay before the nth retry (n starting at 1) as
+// min(base*2^(n-1), ceiling) plus up to one second of jitter, so that many
+// clients backing off at once don't all redial in lockstep.
+func retryDelay(n uint, base, ceiling time.Duration) time.Duration {
+	d := base
+	for i := uint(1); i < n && d < ceiling; i++ {
+		d *= 2
+	}
+	if d > ceiling {
+		d = ceiling
+	}
+	return d + time.Duration(rand.Int63n(int64(time.Second)))
+}
+
+// dialWithRetry dials the dcrd websocket RPC server, retrying transient
+// failures with a truncated exponential backoff.
+func dialWithRetry(ctx context.Context, tc *tls.Config) (*wsrpc.Client, error) {
+	c, err := wsrpc.Dial(ctx, *connectFlag, wsrpc.WithTLSConfig(tc),
+		wsrpc.WithBasicAuth(*userFlag, *passFlag))
+	for n := uint(1); err != nil; n++ {
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		if !isRetryableError(err) || n > *maxRetriesFlag {
+			return nil, err
+		}
+		delay := retryDelay(n, *retryBaseFlag, *retryCeilingFlag)
+		log.Printf("dial: retrying after error (attempt %d/%d): %v",
+			n, *maxRetriesFlag, err)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+		c, err = wsrpc.Dial(ctx, *connectFlag, wsrpc.WithTLSConfig(tc),
+			wsrpc.WithBasicAuth(*userFlag, *passFlag))
+	}
+	return c, nil
+}
+
+// bucketRecord reports aggregate used/unused counts for one scanned
+// bucket.
+type bucketRecord struct {
+	Branch uint32  `json:"branch"`
+	Start  uint32  `json:"bucketStart"`
+	End    uint32  `json:"bucketEnd"`
+	Used   uint32  `json:"used"`
+	Unused uint32  `json:"unused"`
+	Ratio  float64 `json:"ratio"`
+}
+
+// addressRecord reports a single derived address and its used bit.  Only
+// populated when -emit-addresses is set.
+type addressRecord struct {
+	Branch  uint32 `json:"branch"`
+	Address string `json:"address"`
+	Used    bool   `json:"used"`
+}
+
+// totalRecord reports the accumulated totals for one branch.
+type totalRecord struct {
+	Branch uint32  `json:"branch"`
+	Used   uint32  `json:"used"`
+	Unused uint32  `json:"unused"`
+	Ratio  float64 `json:"ratio"`
+}
+
+// reporter emits scan results in some output format.  scanBuckets calls
+// bucket and address (in bucket order) as results come in, then
+// branchStats calls total once the branch is fully scanned; close is
+// called once after all
+// branches are done to flush any buffered output.
+type reporter interface {
+	bucket(rec bucketRecord, single bool) error
+	address(rec addressRecord) error
+	total(rec totalRecord) error
+	close() error
+}
+
+func newReporter(format string) (reporter, error) {
+	switch format {
+	case "", "text":
+		return textReporter{}, nil
+	case "json":
+		return &jsonReporter{}, nil
+	case "ndjson":
+		return &ndjsonReporter{enc: json.NewEncoder(os.Stdout)}, nil
+	case "csv":
+		return &csvReporter{w: csv.NewWriter(os.Stdout)}, nil
+	default:
+		return nil, fmt.Errorf("unknown -format %q", format)
+	}
+}
+
+// textReporter reproduces the tool's original fmt.Printf output.
+type textReporter struct{}
+
+func (textReporter) bucket(rec bucketRecord, single bool) error {
+	if single {
+		return nil
+	}
+	fmt.Printf("%07d-%07d: % 7d used\t% 7d unused\t(%f)\n",
+		rec.Start, rec.End, rec.Used, rec.Unused, rec.Ratio)
+	return nil
+}
+
+func (textReporter) address(rec addressRecord) error {
+	fmt.Printf("  %s used=%t\n", rec.Address, rec.Used)
+	return nil
+}
+
+func (textReporter) total(rec totalRecord) error {
+	fmt.Printf("totals: % 16d used\t% 7d unused\t(%f)\n",
+		rec.Used, rec.Unused, rec.Ratio)
+	return nil
+}
+
+func (textReporter) close() error { return nil }
+
+// ndjsonReporter writes one JSON object per record, newline-delimited, as
+// results are reported.
+type ndjsonReporter struct {
+	enc *json.Encoder
+}
+
+func (r *ndjsonReporter) bucket(rec bucketRecord, single bool) error {
+	return r.enc.Encode(struct {
+		Kind string `json:"kind"`
+		bucketRecord
+	}{"bucket", rec})
+}
+
+func (r *ndjsonReporter) address(rec addressRecord) error {
+	return r.enc.Encode(struct {
+		Kind string `json:"kind"`
+		addressRecord
+	}{"address", rec})
+}
+
+func (r *ndjsonReporter) total(rec totalRecord) error {
+	return r.enc.Encode(struct {
+		Kind string `json:"kind"`
+		totalRecord
+	}{"total", rec})
+}
+
+func (r *ndjsonReporter) close() error { return nil }
+
+// jsonReport is the document written by jsonReporter.close.
+type jsonReport struct {
+	Buckets   []bucketRecord  `json:"buckets"`
+	Addresses []addressRecord `json:"addresses,omitempty"`
+	Totals    []totalRecord   `json:"totals"`
+}
+
+// jsonReporter accumulates every record across all branches into one
+// document, written as a single JSON value on close.
+type jsonReporter struct {
+	report jsonReport
+}
+
+func (r *jsonReporter) bucket(rec bucketRecord, single bool) error {
+	r.report.Buckets = append(r.report.Buckets, rec)
+	return nil
+}
+
+func (r *jsonReporter) address(rec addressRecord) error {
+	r.report.Addresses = append(r.report.Addresses, rec)
+	return nil
+}
+
+func (r *jsonReporter) total(rec totalRecord) error {
+	r.report.Totals = append(r.report.Totals, rec)
+	return nil
+}
+
+func (r *jsonReporter) close() error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r.report)
+}
+
+// csvReporter writes one CSV table covering all record kinds, leaving
+// columns that don't apply to a given row blank.
+type csvReporter struct {
+	w           *csv.Writer
+	wroteHeader bool
+}
+
+var csvHeader = []string{"kind", "branch", "bucketStart", "bucketEnd",
+	"used", "unused", "ratio", "address", "addressUsed"}
+
+func (r *csvReporter) writeHeader() error {
+	if r.wroteHeader {
+		return nil
+	}
+	r.wroteHeader = true
+	return r.w.Write(csvHeader)
+}
+
+func (r *csvReporter) bucket(rec bucketRecord, single bool) error {
+	if err := r.writeHeader(); err != nil {
+		return err
+	}
+	return r.w.Write([]string{"bucket", strconv.FormatUint(uint64(rec.Branch), 10),
+		strconv.FormatUint(uint64(rec.Start), 10), strconv.FormatUint(uint64(rec.End), 10),
+		strconv.FormatUint(uint64(rec.Used), 10), strconv.FormatUint(uint64(rec.Unused), 10),
+		strconv.FormatFloat(rec.Ratio, 'f', -1, 64), "", ""})
+}
+
+func (r *csvReporter) address(rec addressRecord) error {
+	if err := r.writeHeader(); err != nil {
+		return err
+	}
+	return r.w.Write([]string{"address", strconv.FormatUint(uint64(rec.Branch), 10),
+		"", "", "", "", "", rec.Address, strconv.FormatBool(rec.Used)})
+}
+
+func (r *csvReporter) total(rec totalRecord) error {
+	if err := r.writeHeader(); err != nil {
+		return err
+	}
+	return r.w.Write([]string{"total", strconv.FormatUint(uint64(rec.Branch), 10),
+		"", "", strconv.FormatUint(uint64(rec.Used), 10), strconv.FormatUint(uint64(rec.Unused), 10),
+		strconv.FormatFloat(rec.Ratio, 'f', -1, 64), "", ""})
+}
+
+func (r *csvReporter) close() error {
+	r.w.Flush()
+	return r.w.Error()
+}
+
 type usage struct {
+	rpcMu  sync.RWMutex
 	rpc    *dcrd.RPC
+	c      *wsrpc.Client
+	caPool *caPool
 	xpub   *hdkeychain.ExtendedKey
 	params stdaddr.AddressParams
+	rep    reporter
+}
+
+// client returns the current RPC client and the underlying transport it
+// was built from, safe for concurrent use with redial.  Callers pass the
+// returned *wsrpc.Client back into redial so a redial triggered by a
+// failure on a connection that has already been replaced is a no-op.
+func (u *usage) client() (*dcrd.RPC, *wsrpc.Client) {
+	u.rpcMu.RLock()
+	defer u.rpcMu.RUnlock()
+	return u.rpc, u.c
+}
+
+// redial replaces u.c and u.rpc with a freshly dialed connection, closing
+// the previous client.  It is called when an RPC call reports the
+// websocket transport is no longer usable, and may be called concurrently
+// by multiple bucket workers.  stale is the transport the caller observed
+// failing; if u.c has already moved on from stale, another worker has
+// already redialed and this call is a no-op, so concurrent workers never
+// race to close a connection a third worker has since started using.
+func (u *usage) redial(ctx context.Context, stale *wsrpc.Client) error {
+	u.rpcMu.RLock()
+	current := u.c
+	u.rpcMu.RUnlock()
+	if current != stale {
+		return nil
+	}
+
+	tc, err := tlsConfig(u.caPool, *serverNameFlag)
+	if err != nil {
+		return err
+	}
+	c, err := dialWithRetry(ctx, tc)
+	if err != nil {
+		return err
+	}
+
+	u.rpcMu.Lock()
+	if u.c != stale {
+		u.rpcMu.Unlock()
+		c.Close()
+		return nil
+	}
+	old := u.c
+	u.c = c
+	u.rpc = dcrd.New(c)
+	u.rpcMu.Unlock()
+	old.Close()
+	return nil
 }
 
 func (u *usage) stats(ctx context.Context) error {
@@ -131,7 +655,22 @@ func (u *usage) stats(ctx context.Context) error {
 			return err
 		}
 	}
-	return nil
+	return u.rep.close()
+}
+
+// bucketJob describes one [start, end) range of child indices to scan.
+type bucketJob struct {
+	index      int
+	start, end uint32
+}
+
+// bucketResult is a scanned bucketJob's outcome, emitted by a worker and
+// consumed by the collector in scanBuckets.
+type bucketResult struct {
+	bucketJob
+	used, unused uint32
+	addresses    []addressRecord
+	err          error
 }
 
 func (u *usage) branchStats(ctx context.Context, n, begin, bucket,
@@ -140,59 +679,269 @@ func (u *usage) branchStats(ctx context.Context, n, begin, bucket,
 	if err != nil {
 		return err
 	}
-	addrs := make([]stdaddr.Address, 0, n*bucket)
-	var totalUsed, totalUnused uint32
 	if bucket == 0 {
 		bucket = n
 	}
-	for i := uint32(begin); i < n; i += bucket {
-		addrs = addrs[:0]
-		max := i + bucket
-		if max > n {
-			max = n
+	single := bucket == n
+
+	var jobs []bucketJob
+	for start := begin; start < n; start += bucket {
+		end := start + bucket
+		if end > n {
+			end = n
 		}
-		for j := i; j < max; j++ {
-			childKey, err := branchKey.Child(j)
-			if errors.Is(err, hdkeychain.ErrInvalidChild) {
-				continue
+		jobs = append(jobs, bucketJob{index: len(jobs), start: start, end: end})
+	}
+
+	var totalUsed, totalUnused uint32
+	if len(jobs) > 0 {
+		var err error
+		totalUsed, totalUnused, err = u.scanBuckets(ctx, branchKey, branch, jobs, single)
+		if err != nil {
+			return err
+		}
+	}
+
+	return u.rep.total(totalRecord{
+		Branch: branch,
+		Used:   totalUsed,
+		Unused: totalUnused,
+		Ratio:  usedRatio(totalUsed, totalUnused),
+	})
+}
+
+// usedRatio returns used/(used+unused), or 0 if both are zero.  A plain
+// division there yields NaN, which the text and CSV reporters print
+// harmlessly but encoding/json refuses to marshal, so -format json and
+// -format ndjson would otherwise crash on any zero-address branch or
+// bucket, such as a resumed scan where -externalstart equals -external.
+func usedRatio(used, unused uint32) float64 {
+	total := used + unused
+	if total == 0 {
+		return 0
+	}
+	return float64(used) / float64(total)
+}
+
+// scanBuckets fans bucket scans for jobs out across -workers goroutines,
+// deriving each bucket's keys and calling UsedAddresses independently so
+// CPU-bound derivation and latency-bound RPC calls overlap.  A collector
+// reorders results back into bucket order before reporting them, so
+// output is identical to a sequential scan regardless of worker count.
+// On the first error the context is canceled and all workers are drained
+// before returning it.  single indicates the whole range is being scanned
+// as one bucket, which the text reporter uses to suppress a redundant
+// per-bucket line.
+func (u *usage) scanBuckets(ctx context.Context, branchKey *hdkeychain.ExtendedKey,
+	branch uint32, jobs []bucketJob, single bool) (totalUsed, totalUnused uint32, err error) {
+	workers := *workersFlag
+	if workers == 0 {
+		workers = 1
+	}
+	if workers > uint(len(jobs)) {
+		workers = uint(len(jobs))
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	jobCh := make(chan bucketJob)
+	resultCh := make(chan bucketResult)
+
+	var wg sync.WaitGroup
+	wg.Add(int(workers))
+	for i := uint(0); i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for j := range jobCh {
+				used, unused, addrs, err := u.scanBucket(ctx, branchKey, branch, j.start, j.end)
+				resultCh <- bucketResult{bucketJob: j, used: used, unused: unused,
+					addresses: addrs, err: err}
+			}
+		}()
+	}
+	go func() {
+		defer close(jobCh)
+		for _, j := range jobs {
+			select {
+			case jobCh <- j:
+			case <-ctx.Done():
+				return
 			}
-			if err != nil {
-				return err
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(resultCh)
+	}()
+
+	pending := make(map[int]bucketResult)
+	next := 0
+	var firstErr error
+	for res := range resultCh {
+		if res.err != nil {
+			if firstErr == nil {
+				firstErr = res.err
+				cancel()
 			}
-			a, err := u.addr(childKey)
-			if err != nil {
-				return err
+			continue
+		}
+		pending[res.index] = res
+		for {
+			r, ok := pending[next]
+			if !ok {
+				break
 			}
-			addrs = append(addrs, a)
+			delete(pending, next)
+			totalUsed += r.used
+			totalUnused += r.unused
+			if firstErr == nil {
+				err := u.rep.bucket(bucketRecord{
+					Branch: branch,
+					Start:  r.start,
+					End:    r.end,
+					Used:   r.used,
+					Unused: r.unused,
+					Ratio:  usedRatio(r.used, r.unused),
+				}, single)
+				if err == nil {
+					for _, a := range r.addresses {
+						if err = u.rep.address(a); err != nil {
+							break
+						}
+					}
+				}
+				if err != nil {
+					firstErr = err
+					cancel()
+				}
+			}
+			next++
+		}
+	}
+	if firstErr != nil {
+		return 0, 0, firstErr
+	}
+	return totalUsed, totalUnused, nil
+}
+
+// scanBucket derives the child addresses for [start, end) on branchKey and
+// queries their used status, retrying transient RPC failures with backoff
+// and redialing on a closed transport.  When -emit-addresses is set, the
+// derived address strings and their used bit are also returned.  Each
+// child key may contribute more than one address (its -algo address and,
+// when -p2sh-redeem is set, a P2SH redeem script address); results for
+// all of them are merged into the same used/unused totals.
+func (u *usage) scanBucket(ctx context.Context, branchKey *hdkeychain.ExtendedKey,
+	branch, start, end uint32) (used, unused uint32, addresses []addressRecord, err error) {
+	addrs := make([]stdaddr.Address, 0, end-start)
+	for j := start; j < end; j++ {
+		childKey, err := branchKey.Child(j)
+		if errors.Is(err, hdkeychain.ErrInvalidChild) {
+			continue
 		}
-		usedBits, err := u.rpc.UsedAddresses(ctx, addrs)
 		if err != nil {
-			return err
+			return 0, 0, nil, err
+		}
+		as, err := u.addr(childKey)
+		if err != nil {
+			return 0, 0, nil, err
+		}
+		addrs = append(addrs, as...)
+	}
+
+	rpc, c := u.client()
+	usedBits, err := rpc.UsedAddresses(ctx, addrs)
+	for n := uint(1); err != nil; n++ {
+		if ctx.Err() != nil {
+			return 0, 0, nil, ctx.Err()
 		}
-		var used, unused uint32
-		for j := range addrs {
-			if usedBits.Get(j) {
-				used++
-			} else {
-				unused++
+		if !isRetryableError(err) || n > *maxRetriesFlag {
+			return 0, 0, nil, err
+		}
+		if isClosedConnError(err) {
+			if derr := u.redial(ctx, c); derr != nil {
+				return 0, 0, nil, derr
 			}
 		}
-		totalUsed += used
-		totalUnused += unused
-		if bucket == n {
-			continue
+		delay := retryDelay(n, *retryBaseFlag, *retryCeilingFlag)
+		log.Printf("UsedAddresses: retrying after error (attempt %d/%d): %v",
+			n, *maxRetriesFlag, err)
+		select {
+		case <-ctx.Done():
+			return 0, 0, nil, ctx.Err()
+		case <-time.After(delay):
 		}
-		fmt.Printf("%07d-%07d: % 7d used\t% 7d unused\t(%f)\n", i, max,
-			used, unused, float64(used)/float64(len(addrs)))
+		rpc, c = u.client()
+		usedBits, err = rpc.UsedAddresses(ctx, addrs)
 	}
-	total := totalUsed + totalUnused
-	fmt.Printf("totals: % 16d used\t% 7d unused\t(%f)\n",
-		totalUsed, totalUnused, float64(totalUsed)/float64(total))
-	return nil
+
+	if *emitAddressesFlag {
+		addresses = make([]addressRecord, len(addrs))
+	}
+	for j := range addrs {
+		isUsed := usedBits.Get(j)
+		if isUsed {
+			used++
+		} else {
+			unused++
+		}
+		if *emitAddressesFlag {
+			addresses[j] = addressRecord{
+				Branch:  branch,
+				Address: addrs[j].String(),
+				Used:    isUsed,
+			}
+		}
+	}
+	return used, unused, addresses, nil
 }
 
-func (u *usage) addr(key *hdkeychain.ExtendedKey) (stdaddr.Address, error) {
+// addr derives the addresses to query for a child key: its -algo pubkey
+// hash address, plus a P2SH redeem script address when -p2sh-redeem is
+// set, so wallets mixing signature algorithms and multisig branches can
+// be audited in one run.
+func (u *usage) addr(key *hdkeychain.ExtendedKey) ([]stdaddr.Address, error) {
 	pk := key.SerializedPubKey()
+	a, err := pubKeyHashAddr(*algoFlag, pk, u.params)
+	if err != nil {
+		return nil, err
+	}
+	addrs := []stdaddr.Address{a}
+	if *p2shRedeemFlag != "" {
+		p2sh, err := p2shRedeemAddr(*p2shRedeemFlag, pk, u.params)
+		if err != nil {
+			return nil, err
+		}
+		addrs = append(addrs, p2sh)
+	}
+	return addrs, nil
+}
+
+// pubKeyHashAddr derives the V0 pubkey hash address for pk using the
+// signature algorithm named by algo.
+func pubKeyHashAddr(algo string, pk []byte, params stdaddr.AddressParams) (stdaddr.Address, error) {
 	hash := stdaddr.Hash160(pk)
-	return stdaddr.NewAddressPubKeyHashEcdsaSecp256k1V0(hash, u.params)
+	switch algo {
+	case "", "ecdsa-secp256k1":
+		return stdaddr.NewAddressPubKeyHashEcdsaSecp256k1V0(hash, params)
+	case "ed25519":
+		return stdaddr.NewAddressPubKeyHashEd25519V0(hash, params)
+	case "schnorr-secp256k1":
+		return stdaddr.NewAddressPubKeyHashSchnorrSecp256k1V0(hash, params)
+	default:
+		return nil, fmt.Errorf("unknown -algo %q", algo)
+	}
+}
+
+// p2shRedeemAddr substitutes pk's hex encoding into tmpl's {pubkey}
+// placeholders and derives the P2SH address of the resulting redeem
+// script.
+func p2shRedeemAddr(tmpl string, pk []byte, params stdaddr.AddressParams) (stdaddr.Address, error) {
+	script, err := hex.DecodeString(strings.ReplaceAll(tmpl, "{pubkey}", hex.EncodeToString(pk)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid -p2sh-redeem script: %w", err)
+	}
+	hash := stdaddr.Hash160(script)
+	return stdaddr.NewAddressScriptHashV0(hash, params)
 }